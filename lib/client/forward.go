@@ -0,0 +1,58 @@
+/*
+Copyright 2015 Gravitational, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package client
+
+import (
+	log "github.com/Sirupsen/logrus"
+	"github.com/gravitational/trace"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/agent"
+)
+
+// ForwardToSession enables SSH agent forwarding for session established
+// over client, gated on cfg.ForwardAgent (set by the `tsh ssh -A` flag): it
+// registers this agent (or the external sshAgent, when present, so a
+// single real agent is shared across hops) to answer
+// "auth-agent@openssh.com" channels opened by the remote side, then sends
+// the "auth-agent-req@openssh.com" request that tells the remote end to
+// start forwarding. This lets a chain of `ssh -A` hops off of a Teleport
+// session keep signing with the caller's local key.
+//
+// Today this gate is enforced client-side only: the node has no
+// role-based check of its own and will honor auth-agent-req@openssh.com
+// from any client that sends it, regardless of cfg.ForwardAgent. A cluster
+// admin cannot yet disable forwarding fleet-wide short of patching every
+// client -- that requires node-side enforcement (e.g. a
+// RoleSpecV1.ForwardAgent check before the node accepts the forwarding
+// request), which does not exist yet.
+func (a *LocalKeyAgent) ForwardToSession(client *ssh.Client, session *ssh.Session, cfg *Config) error {
+	if cfg == nil || !cfg.ForwardAgent {
+		return nil
+	}
+	forwarded := agent.Agent(a)
+	if a.sshAgent != nil {
+		forwarded = a.sshAgent
+	}
+	if err := agent.ForwardToAgent(client, forwarded); err != nil {
+		return trace.Wrap(err)
+	}
+	if err := agent.RequestAgentForwarding(session); err != nil {
+		return trace.Wrap(err)
+	}
+	log.Debugf("[KEY AGENT] agent forwarding enabled for session")
+	return nil
+}
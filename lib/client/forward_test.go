@@ -0,0 +1,174 @@
+/*
+Copyright 2015 Gravitational, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package client
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"fmt"
+	"net"
+	"testing"
+
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/agent"
+)
+
+// TestForwardToSession spins up an in-process SSH server that, upon
+// receiving the auth-agent-req@openssh.com request on a session, opens an
+// auth-agent@openssh.com channel back to the client and uses it to sign
+// with the forwarded key -- emulating a chained `ssh -A` hop off of a
+// Teleport session.
+func TestForwardToSession(t *testing.T) {
+	clientRSAKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("generating client key: %v", err)
+	}
+	clientKey, err := ssh.NewSignerFromKey(clientRSAKey)
+	if err != nil {
+		t.Fatalf("wrapping client key: %v", err)
+	}
+
+	localAgent := agent.NewKeyring()
+	if err := localAgent.Add(agent.AddedKey{PrivateKey: clientRSAKey}); err != nil {
+		t.Fatalf("adding key to local agent: %v", err)
+	}
+	a := &LocalKeyAgent{Agent: localAgent}
+
+	hostRSAKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("generating host key: %v", err)
+	}
+	hostKey, err := ssh.NewSignerFromKey(hostRSAKey)
+	if err != nil {
+		t.Fatalf("wrapping host key: %v", err)
+	}
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listening: %v", err)
+	}
+	defer listener.Close()
+
+	signedWithForwardedKey := make(chan error, 1)
+	go runForwardingServer(t, listener, hostKey, signedWithForwardedKey)
+
+	clientConfig := &ssh.ClientConfig{
+		User:            "alice",
+		Auth:            []ssh.AuthMethod{ssh.PublicKeys(clientKey)},
+		HostKeyCallback: ssh.InsecureIgnoreHostKey(),
+	}
+	conn, err := ssh.Dial("tcp", listener.Addr().String(), clientConfig)
+	if err != nil {
+		t.Fatalf("dialing: %v", err)
+	}
+	defer conn.Close()
+
+	session, err := conn.NewSession()
+	if err != nil {
+		t.Fatalf("opening session: %v", err)
+	}
+	defer session.Close()
+
+	if err := a.ForwardToSession(conn, session, &Config{ForwardAgent: true}); err != nil {
+		t.Fatalf("ForwardToSession: %v", err)
+	}
+
+	if err := <-signedWithForwardedKey; err != nil {
+		t.Fatalf("server could not sign via the forwarded agent: %v", err)
+	}
+}
+
+// TestForwardToSessionDisabled verifies that ForwardToSession is a no-op
+// when the caller didn't request agent forwarding, so `tsh ssh` without
+// -A never requests auth-agent-req@openssh.com.
+func TestForwardToSessionDisabled(t *testing.T) {
+	a := &LocalKeyAgent{Agent: agent.NewKeyring()}
+	if err := a.ForwardToSession(nil, nil, &Config{ForwardAgent: false}); err != nil {
+		t.Fatalf("expected no-op, got error: %v", err)
+	}
+	if err := a.ForwardToSession(nil, nil, nil); err != nil {
+		t.Fatalf("expected no-op with nil config, got error: %v", err)
+	}
+}
+
+// runForwardingServer accepts a single SSH connection, authenticating any
+// public key, then waits for a session channel and its
+// auth-agent-req@openssh.com request. Once received, it opens an
+// auth-agent@openssh.com channel back to the client and signs a challenge
+// with the forwarded key, reporting the result on done.
+func runForwardingServer(t *testing.T, listener net.Listener, hostKey ssh.Signer, done chan<- error) {
+	nc, err := listener.Accept()
+	if err != nil {
+		done <- err
+		return
+	}
+	defer nc.Close()
+
+	config := &ssh.ServerConfig{NoClientAuth: true}
+	config.AddHostKey(hostKey)
+
+	conn, chans, reqs, err := ssh.NewServerConn(nc, config)
+	if err != nil {
+		done <- err
+		return
+	}
+	defer conn.Close()
+	go ssh.DiscardRequests(reqs)
+
+	for newChannel := range chans {
+		if newChannel.ChannelType() != "session" {
+			newChannel.Reject(ssh.UnknownChannelType, "unsupported channel type")
+			continue
+		}
+		channel, requests, err := newChannel.Accept()
+		if err != nil {
+			done <- err
+			return
+		}
+		defer channel.Close()
+
+		for req := range requests {
+			if req.WantReply {
+				req.Reply(true, nil)
+			}
+			if req.Type != "auth-agent-req@openssh.com" {
+				continue
+			}
+			agentChannel, agentRequests, err := conn.OpenChannel("auth-agent@openssh.com", nil)
+			if err != nil {
+				done <- err
+				return
+			}
+			go ssh.DiscardRequests(agentRequests)
+			defer agentChannel.Close()
+
+			forwardedAgent := agent.NewClient(agentChannel)
+			keys, err := forwardedAgent.List()
+			if err != nil {
+				done <- err
+				return
+			}
+			if len(keys) != 1 {
+				done <- fmt.Errorf("expected exactly one forwarded key, got %d", len(keys))
+				return
+			}
+			_, err = forwardedAgent.Sign(keys[0], []byte("challenge"))
+			done <- err
+			return
+		}
+	}
+}
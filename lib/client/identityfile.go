@@ -0,0 +1,215 @@
+/*
+Copyright 2015 Gravitational, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package client
+
+import (
+	"crypto/ecdsa"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/binary"
+	"encoding/pem"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/gravitational/trace"
+	"golang.org/x/crypto/ed25519"
+	"golang.org/x/crypto/ssh"
+)
+
+// Config holds the settings needed to dial a Teleport proxy and
+// authenticate as a user, as parsed from either discrete flags or a single
+// connection URI.
+type Config struct {
+	// Username is the Teleport user to authenticate as.
+	Username string
+	// ProxyHost is the proxy's hostname.
+	ProxyHost string
+	// ProxyPort is the proxy's SSH port.
+	ProxyPort int
+	// Path is left over from the URI for subcommands that take one, e.g.
+	// `tsh play <session-id>` or `tsh scp <path>`.
+	Path string
+
+	// ForwardAgent is set by `tsh ssh -A` to request SSH agent forwarding
+	// for the session. It is checked on the client side only, by
+	// ForwardToSession; the node applies no role-based check of its own.
+	ForwardAgent bool
+}
+
+// ParseConnectionURI parses an OpenSSH-style connection URI, e.g.
+// "ssh://alice@proxy.example.com:3023/some/path", into a Config. Username,
+// port, and path are optional and left zero-valued when absent.
+func ParseConnectionURI(uri string) (*Config, error) {
+	u, err := url.Parse(uri)
+	if err != nil {
+		return nil, trace.Wrap(err)
+	}
+	if u.Scheme != "ssh" {
+		return nil, trace.BadParameter("unsupported connection URI scheme %q, expected \"ssh\"", u.Scheme)
+	}
+	if u.Hostname() == "" {
+		return nil, trace.BadParameter("connection URI %q is missing a host", uri)
+	}
+	cfg := &Config{
+		ProxyHost: u.Hostname(),
+		Path:      strings.TrimPrefix(u.Path, "/"),
+	}
+	if u.User != nil {
+		cfg.Username = u.User.Username()
+	}
+	if port := u.Port(); port != "" {
+		p, err := strconv.Atoi(port)
+		if err != nil {
+			return nil, trace.BadParameter("invalid port in connection URI %q: %v", uri, err)
+		}
+		cfg.ProxyPort = p
+	}
+	return cfg, nil
+}
+
+// LoadIdentityFile reads an OpenSSH private key from path (and its
+// matching "<path>-cert.pub", if present) and returns it as a *Key. If the
+// private key is itself encrypted -- a plain OpenSSH passphrase-protected
+// PEM blob, not one of Teleport's own TELEPORT-ENCRYPTED-KEY-V1 keystore
+// entries -- and passphrase is empty, it obtains one via ReadPassphrase
+// (TELEPORT_KEY_PASSPHRASE if set, otherwise a stdin prompt); if passphrase
+// is non-empty it's used directly without prompting.
+func (a *LocalKeyAgent) LoadIdentityFile(path string, passphrase []byte) (*Key, error) {
+	priv, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, trace.Wrap(err)
+	}
+	var cert []byte
+	cert, err = ioutil.ReadFile(path + "-cert.pub")
+	if err != nil && !os.IsNotExist(err) {
+		return nil, trace.Wrap(err)
+	}
+	key := &Key{Priv: priv, Cert: cert}
+
+	if _, err := ssh.ParseRawPrivateKey(priv); err != nil {
+		if _, ok := err.(*ssh.PassphraseMissingError); !ok {
+			return nil, trace.Wrap(err)
+		}
+		if len(passphrase) == 0 {
+			passphrase, err = ReadPassphrase("")
+			if err != nil {
+				return nil, trace.Wrap(err)
+			}
+		}
+		decrypted, err := ssh.ParseRawPrivateKeyWithPassphrase(priv, passphrase)
+		if err != nil {
+			return nil, trace.AccessDenied("invalid passphrase")
+		}
+		plainPEM, err := marshalRawPrivateKey(decrypted)
+		if err != nil {
+			return nil, trace.Wrap(err)
+		}
+		key.Priv = plainPEM
+	}
+
+	agentKey, err := key.AsAgentKey()
+	if err != nil {
+		return nil, trace.Wrap(err)
+	}
+	if err := a.Agent.Add(*agentKey); err != nil {
+		return nil, trace.Wrap(err)
+	}
+	if a.sshAgent != nil {
+		if err := a.sshAgent.Add(*agentKey); err != nil {
+			log.Warn(err)
+		}
+	}
+	return key, nil
+}
+
+// marshalRawPrivateKey re-encodes a key decrypted by
+// ssh.ParseRawPrivateKeyWithPassphrase back into an unencrypted PEM blob,
+// the form the rest of this package (AsAgentKey, EncryptKey/DecryptKey)
+// expects to find in Key.Priv.
+func marshalRawPrivateKey(raw interface{}) ([]byte, error) {
+	switch k := raw.(type) {
+	case *rsa.PrivateKey:
+		return pem.EncodeToMemory(&pem.Block{
+			Type:  "RSA PRIVATE KEY",
+			Bytes: x509.MarshalPKCS1PrivateKey(k),
+		}), nil
+	case *ecdsa.PrivateKey:
+		der, err := x509.MarshalECPrivateKey(k)
+		if err != nil {
+			return nil, trace.Wrap(err)
+		}
+		return pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: der}), nil
+	case *ed25519.PrivateKey:
+		return marshalOpenSSHEd25519PrivateKey(*k)
+	default:
+		return nil, trace.BadParameter("unsupported private key type %T", raw)
+	}
+}
+
+// marshalOpenSSHEd25519PrivateKey encodes priv into the "openssh-key-v1"
+// private key format -- ed25519 has no classic PKCS1/SEC1 PEM encoding, so
+// OpenSSH (and ssh-keygen's default key type) always stores it this way,
+// unencrypted ("none" cipher/kdf) since the passphrase was already consumed
+// decrypting it.
+func marshalOpenSSHEd25519PrivateKey(priv ed25519.PrivateKey) ([]byte, error) {
+	pub := priv[32:]
+
+	checkint := make([]byte, 4)
+	if _, err := rand.Read(checkint); err != nil {
+		return nil, trace.Wrap(err)
+	}
+
+	var private []byte
+	private = append(private, checkint...)
+	private = append(private, checkint...)
+	private = appendOpenSSHString(private, []byte(ssh.KeyAlgoED25519))
+	private = appendOpenSSHString(private, pub)
+	private = appendOpenSSHString(private, priv)
+	private = appendOpenSSHString(private, nil) // comment
+	for i := byte(1); len(private)%8 != 0; i++ {
+		private = append(private, i)
+	}
+
+	var publicKey []byte
+	publicKey = appendOpenSSHString(publicKey, []byte(ssh.KeyAlgoED25519))
+	publicKey = appendOpenSSHString(publicKey, pub)
+
+	var out []byte
+	out = append(out, "openssh-key-v1\x00"...)
+	out = appendOpenSSHString(out, []byte("none")) // ciphername
+	out = appendOpenSSHString(out, []byte("none")) // kdfname
+	out = appendOpenSSHString(out, nil)            // kdfoptions
+	out = append(out, 0, 0, 0, 1)                  // number of keys
+	out = appendOpenSSHString(out, publicKey)
+	out = appendOpenSSHString(out, private)
+
+	return pem.EncodeToMemory(&pem.Block{Type: "OPENSSH PRIVATE KEY", Bytes: out}), nil
+}
+
+// appendOpenSSHString appends b to dst as a 4-byte-big-endian-length-prefixed
+// string, the primitive the openssh-key-v1 format is built out of.
+func appendOpenSSHString(dst, b []byte) []byte {
+	length := make([]byte, 4)
+	binary.BigEndian.PutUint32(length, uint32(len(b)))
+	dst = append(dst, length...)
+	return append(dst, b...)
+}
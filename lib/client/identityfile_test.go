@@ -0,0 +1,58 @@
+/*
+Copyright 2015 Gravitational, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package client
+
+import (
+	"bytes"
+	"crypto/rand"
+	"testing"
+
+	"golang.org/x/crypto/ed25519"
+	"golang.org/x/crypto/ssh"
+)
+
+// TestMarshalRawPrivateKeyEd25519 verifies that an ed25519 key decrypted by
+// ssh.ParseRawPrivateKeyWithPassphrase -- the modern ssh-keygen default key
+// type -- can be re-encoded and parsed back as the same key, instead of
+// falling through marshalRawPrivateKey's "unsupported private key type"
+// default case.
+func TestMarshalRawPrivateKeyEd25519(t *testing.T) {
+	pub, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatalf("generating ed25519 key: %v", err)
+	}
+
+	pem, err := marshalRawPrivateKey(&priv)
+	if err != nil {
+		t.Fatalf("marshalRawPrivateKey: %v", err)
+	}
+
+	parsed, err := ssh.ParseRawPrivateKey(pem)
+	if err != nil {
+		t.Fatalf("parsing re-encoded key: %v", err)
+	}
+	roundTripped, ok := parsed.(*ed25519.PrivateKey)
+	if !ok {
+		t.Fatalf("expected *ed25519.PrivateKey back, got %T", parsed)
+	}
+	if !bytes.Equal(*roundTripped, priv) {
+		t.Fatal("round-tripped private key does not match the original")
+	}
+	if !bytes.Equal((*roundTripped)[32:], pub) {
+		t.Fatal("round-tripped public half does not match the original")
+	}
+}
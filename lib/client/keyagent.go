@@ -22,6 +22,7 @@ import (
 	"net"
 	"os"
 	"strings"
+	"time"
 
 	"github.com/gravitational/teleport/lib/services"
 	"github.com/gravitational/teleport/lib/sshutils"
@@ -32,6 +33,11 @@ import (
 	"golang.org/x/crypto/ssh/agent"
 )
 
+// PassphraseCallback is invoked by LocalKeyAgent whenever it encounters a
+// private key that's encrypted at rest. It should return the passphrase to
+// decrypt it, typically after prompting the user.
+type PassphraseCallback func() ([]byte, error)
+
 type LocalKeyAgent struct {
 	// implements ssh agent.Agent interface
 	agent.Agent
@@ -39,19 +45,34 @@ type LocalKeyAgent struct {
 
 	// sshAgent is the external SSH agent
 	sshAgent agent.Agent
+
+	// passphrase is invoked on demand to unlock keys that are stored
+	// encrypted. It is nil when no passphrase callback was configured, in
+	// which case encrypted keys can only be loaded via
+	// TELEPORT_KEY_PASSPHRASE.
+	passphrase PassphraseCallback
+
+	// cas holds every CA handed to AddHostSignersToCache, alongside the
+	// keyStore's opaque known_hosts entries, so callers that need the full
+	// CertAuthorityV1 record (e.g. to render known_hosts/ssh_config for
+	// plain OpenSSH clients) don't have to go back through the proxy.
+	cas []services.CertAuthorityV1
 }
 
 // NewLocalAgent loads all the saved teleport certificates and
-// creates ssh agent with them
-func NewLocalAgent(keyDir, username string) (a *LocalKeyAgent, err error) {
+// creates ssh agent with them. passphrase may be nil, in which case
+// encrypted keys will fail to load with a trace.AccessDenied error unless
+// TELEPORT_KEY_PASSPHRASE is set.
+func NewLocalAgent(keyDir, username string, passphrase PassphraseCallback) (a *LocalKeyAgent, err error) {
 	keystore, err := NewFSLocalKeyStore(keyDir)
 	if err != nil {
 		return nil, trace.Wrap(err)
 	}
 	a = &LocalKeyAgent{
-		Agent:    agent.NewKeyring(),
-		keyStore: keystore,
-		sshAgent: connectToSSHAgent(),
+		Agent:      agent.NewKeyring(),
+		keyStore:   keystore,
+		sshAgent:   connectToSSHAgent(),
+		passphrase: passphrase,
 	}
 	// load all stored keys from disk (~/.tsh usually) and pass them into the agent:
 	keys, err := a.LoadKeys(username)
@@ -67,7 +88,8 @@ func NewLocalAgent(keyDir, username string) (a *LocalKeyAgent, err error) {
 }
 
 // loadKeys return the list of keys for the given user
-// from the local keystore (files in ~/.tsh)
+// from the local keystore (files in ~/.tsh), decrypting any of them
+// that are passphrase-protected along the way.
 func (a *LocalKeyAgent) LoadKeys(username string) ([]agent.AddedKey, error) {
 	keys, err := a.keyStore.GetKeys(username)
 	if err != nil {
@@ -75,6 +97,12 @@ func (a *LocalKeyAgent) LoadKeys(username string) ([]agent.AddedKey, error) {
 	}
 	retval := make([]agent.AddedKey, len(keys))
 	for i, key := range keys {
+		if IsEncryptedKey(key) {
+			key, err = a.decryptKey(key)
+			if err != nil {
+				return nil, trace.Wrap(err)
+			}
+		}
 		ak, err := key.AsAgentKey()
 		if err != nil {
 			return nil, trace.Wrap(err)
@@ -84,6 +112,50 @@ func (a *LocalKeyAgent) LoadKeys(username string) ([]agent.AddedKey, error) {
 	return retval, nil
 }
 
+// decryptKey returns a copy of key with its private key material decrypted,
+// obtaining the passphrase from TELEPORT_KEY_PASSPHRASE, then from the
+// configured passphrase callback.
+func (a *LocalKeyAgent) decryptKey(key *Key) (*Key, error) {
+	passphrase := []byte(os.Getenv("TELEPORT_KEY_PASSPHRASE"))
+	if len(passphrase) == 0 {
+		if a.passphrase == nil {
+			return nil, trace.AccessDenied("key is passphrase-protected and no passphrase was provided")
+		}
+		var err error
+		passphrase, err = a.passphrase()
+		if err != nil {
+			return nil, trace.Wrap(err)
+		}
+	}
+	return DecryptKey(key, passphrase)
+}
+
+// ChangePassphrase re-encrypts all keys stored for username under a new
+// passphrase, first verifying old against the existing encrypted blobs.
+func (a *LocalKeyAgent) ChangePassphrase(username string, old, new []byte) error {
+	keys, err := a.keyStore.GetKeys(username)
+	if err != nil {
+		return trace.Wrap(err)
+	}
+	for _, key := range keys {
+		if !IsEncryptedKey(key) {
+			continue
+		}
+		plain, err := DecryptKey(key, old)
+		if err != nil {
+			return trace.Wrap(err)
+		}
+		reencrypted, err := EncryptKey(plain, new)
+		if err != nil {
+			return trace.Wrap(err)
+		}
+		if err := a.keyStore.AddKey(key.ProxyHost, username, reencrypted); err != nil {
+			return trace.Wrap(err)
+		}
+	}
+	return nil
+}
+
 // AddHostSignersToCache takes a list of CAs whom we trust. This list is added to a database
 // of "seen" CAs.
 //
@@ -101,6 +173,7 @@ func (a *LocalKeyAgent) AddHostSignersToCache(hostSigners []services.CertAuthori
 		}
 		log.Debugf("[KEY AGENT] adding CA key for %s", hostSigner.DomainName)
 		a.keyStore.AddKnownHostKeys(hostSigner.DomainName, publicKeys)
+		a.cas = append(a.cas, hostSigner)
 	}
 	return nil
 }
@@ -137,26 +210,72 @@ func (a *LocalKeyAgent) CheckHostSignature(hostId string, remote net.Addr, key s
 		return nil
 	}
 
-	// we are given a certificate. see if it was signed by any of the known_host keys:
+	// we are given a host certificate: verify it was (a) signed by a CA we know,
+	// (b) actually a host cert, (c) issued for this host, and (d) still valid.
 	keys, err := a.keyStore.GetKnownHostKeys("")
 	if err != nil {
 		log.Error(err)
 		return trace.Wrap(err)
 	}
 	log.Debugf("[KEY AGENT] got %d known hosts", len(keys))
+	knownCA := false
 	for i := range keys {
 		if sshutils.KeysEqual(cert.SignatureKey, keys[i]) {
-			log.Debugf("[KEY AGENT] verified host %s", hostId)
-			return nil
+			knownCA = true
+			break
 		}
 	}
-	err = trace.AccessDenied("untrusted host %v", hostId)
-	log.Error(err)
-	return err
+	if !knownCA {
+		err = trace.AccessDenied("untrusted host %v", hostId)
+		log.Error(err)
+		return err
+	}
+	if cert.CertType != ssh.HostCert {
+		err = trace.AccessDenied("expected a host certificate for %v, got something else", hostId)
+		log.Error(err)
+		return err
+	}
+	principalMatch := false
+	for _, p := range cert.ValidPrincipals {
+		if p == hostId {
+			principalMatch = true
+			break
+		}
+	}
+	if !principalMatch {
+		err = trace.AccessDenied("certificate for %v is not valid for this host", hostId)
+		log.Error(err)
+		return err
+	}
+	now := uint64(time.Now().Unix())
+	if now < cert.ValidAfter || now >= cert.ValidBefore {
+		err = trace.AccessDenied("certificate for %v has expired or is not yet valid", hostId)
+		log.Error(err)
+		return err
+	}
+	log.Debugf("[KEY AGENT] verified host %s", hostId)
+	return nil
 }
 
+// AddKey persists key to the local keystore and loads it into the agent.
+// When a passphrase callback was configured (see NewLocalAgent), key is
+// encrypted at rest before being written to disk -- the agent and any
+// external sshAgent still receive the plaintext key, since signing needs
+// the key material in memory regardless of how it's stored on disk.
 func (a *LocalKeyAgent) AddKey(host string, username string, key *Key) error {
-	err := a.keyStore.AddKey(host, username, key)
+	toStore := key
+	if a.passphrase != nil && !IsEncryptedKey(key) {
+		passphrase, err := a.passphrase()
+		if err != nil {
+			return trace.Wrap(err)
+		}
+		encrypted, err := EncryptKey(key, passphrase)
+		if err != nil {
+			return trace.Wrap(err)
+		}
+		toStore = encrypted
+	}
+	err := a.keyStore.AddKey(host, username, toStore)
 	if err != nil {
 		return trace.Wrap(err)
 	}
@@ -205,7 +324,15 @@ func (a *LocalKeyAgent) DeleteKey(proxyHost string, username string) error {
 // It returns two:
 //	  1. First to try is the external SSH agent
 //    2. Itself (disk-based local agent)
-func (a *LocalKeyAgent) AuthMethods() (m []ssh.AuthMethod) {
+// When the keystore is backed by external signers (hardware tokens, KMS,
+// etc. via SignerKeyStore) their ssh.PublicKeysCallback method is used
+// instead, since those private keys are never loaded into this process --
+// scoped to proxyHost/username so one destination can't be offered another
+// identity's signer.
+func (a *LocalKeyAgent) AuthMethods(proxyHost, username string) (m []ssh.AuthMethod) {
+	if sks, ok := a.keyStore.(*SignerKeyStore); ok {
+		return []ssh.AuthMethod{sks.AuthMethodFromSigners(proxyHost, username)}
+	}
 	m = append(m, authMethodFromAgent(a))
 	if a.sshAgent != nil {
 		m = append(m, authMethodFromAgent(a.sshAgent))
@@ -0,0 +1,146 @@
+/*
+Copyright 2015 Gravitational, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package client
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+
+	"github.com/gravitational/trace"
+	"golang.org/x/crypto/pbkdf2"
+	"golang.org/x/crypto/sha3"
+	"golang.org/x/crypto/ssh/terminal"
+)
+
+// keyEncryptionMarker is prepended to a key's private key blob when it has
+// been encrypted by EncryptKey, so IsEncryptedKey can tell an encrypted
+// blob apart from a plain OpenSSH private key without attempting to parse it.
+const keyEncryptionMarker = "TELEPORT-ENCRYPTED-KEY-V1:"
+
+const (
+	pbkdf2Iterations = 100000
+	aesKeySize       = 32
+	saltSize         = 16
+)
+
+// IsEncryptedKey returns true if key's private key blob was produced by
+// EncryptKey and therefore requires a passphrase to use.
+func IsEncryptedKey(key *Key) bool {
+	return bytes.HasPrefix(key.Priv, []byte(keyEncryptionMarker))
+}
+
+// EncryptKey returns a copy of key whose private key material is sealed
+// with an AES-256-GCM key derived from passphrase via PBKDF2, OpenSSH style.
+func EncryptKey(key *Key, passphrase []byte) (*Key, error) {
+	salt := make([]byte, saltSize)
+	if _, err := io.ReadFull(rand.Reader, salt); err != nil {
+		return nil, trace.Wrap(err)
+	}
+	block, err := newAESCipher(passphrase, salt)
+	if err != nil {
+		return nil, trace.Wrap(err)
+	}
+	nonce := make([]byte, block.NonceSize())
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return nil, trace.Wrap(err)
+	}
+	sealed := block.Seal(nil, nonce, key.Priv, nil)
+
+	out := *key
+	out.Priv = append([]byte(keyEncryptionMarker), append(salt, append(nonce, sealed...)...)...)
+	return &out, nil
+}
+
+// DecryptKey reverses EncryptKey, returning a copy of key with Priv restored
+// to its plaintext OpenSSH form.
+func DecryptKey(key *Key, passphrase []byte) (*Key, error) {
+	if !IsEncryptedKey(key) {
+		return key, nil
+	}
+	blob := key.Priv[len(keyEncryptionMarker):]
+	if len(blob) < saltSize {
+		return nil, trace.BadParameter("corrupted encrypted key")
+	}
+	salt, rest := blob[:saltSize], blob[saltSize:]
+
+	block, err := newAESCipher(passphrase, salt)
+	if err != nil {
+		return nil, trace.Wrap(err)
+	}
+	nonceSize := block.NonceSize()
+	if len(rest) < nonceSize {
+		return nil, trace.BadParameter("corrupted encrypted key")
+	}
+	nonce, sealed := rest[:nonceSize], rest[nonceSize:]
+
+	plain, err := block.Open(nil, nonce, sealed, nil)
+	if err != nil {
+		return nil, trace.AccessDenied("invalid passphrase")
+	}
+	out := *key
+	out.Priv = plain
+	return &out, nil
+}
+
+func newAESCipher(passphrase, salt []byte) (cipher.AEAD, error) {
+	derived := pbkdf2.Key(passphrase, salt, pbkdf2Iterations, aesKeySize, sha3.New256)
+	block, err := aes.NewCipher(derived)
+	if err != nil {
+		return nil, trace.Wrap(err)
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, trace.Wrap(err)
+	}
+	return aead, nil
+}
+
+// ReadPassphrase resolves a key passphrase from, in order: the
+// TELEPORT_KEY_PASSPHRASE environment variable, passphraseFile (if set),
+// or an interactive stdin prompt.
+func ReadPassphrase(passphraseFile string) ([]byte, error) {
+	if p := os.Getenv("TELEPORT_KEY_PASSPHRASE"); p != "" {
+		return []byte(p), nil
+	}
+	if passphraseFile != "" {
+		data, err := ioutil.ReadFile(passphraseFile)
+		if err != nil {
+			return nil, trace.Wrap(err)
+		}
+		return trimNewline(data), nil
+	}
+	fmt.Print("Enter passphrase for key: ")
+	passphrase, err := terminal.ReadPassword(int(os.Stdin.Fd()))
+	fmt.Println()
+	if err != nil {
+		return nil, trace.Wrap(err)
+	}
+	return passphrase, nil
+}
+
+func trimNewline(b []byte) []byte {
+	for len(b) > 0 && (b[len(b)-1] == '\n' || b[len(b)-1] == '\r') {
+		b = b[:len(b)-1]
+	}
+	return b
+}
@@ -0,0 +1,73 @@
+/*
+Copyright 2015 Gravitational, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package client
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestEncryptDecryptKeyRoundTrip(t *testing.T) {
+	key := &Key{Priv: []byte("plaintext private key material"), Cert: []byte("cert")}
+	if IsEncryptedKey(key) {
+		t.Fatal("a freshly-created key should not look encrypted")
+	}
+
+	encrypted, err := EncryptKey(key, []byte("correct horse battery staple"))
+	if err != nil {
+		t.Fatalf("EncryptKey: %v", err)
+	}
+	if !IsEncryptedKey(encrypted) {
+		t.Fatal("expected the encrypted key to be detected as such")
+	}
+	if bytes.Equal(encrypted.Priv, key.Priv) {
+		t.Fatal("encrypted Priv should not equal the plaintext")
+	}
+	if !bytes.Equal(encrypted.Cert, key.Cert) {
+		t.Fatal("EncryptKey should not alter the certificate")
+	}
+
+	decrypted, err := DecryptKey(encrypted, []byte("correct horse battery staple"))
+	if err != nil {
+		t.Fatalf("DecryptKey: %v", err)
+	}
+	if !bytes.Equal(decrypted.Priv, key.Priv) {
+		t.Fatal("decrypted Priv should match the original plaintext")
+	}
+}
+
+func TestDecryptKeyWrongPassphrase(t *testing.T) {
+	key := &Key{Priv: []byte("plaintext private key material")}
+	encrypted, err := EncryptKey(key, []byte("right passphrase"))
+	if err != nil {
+		t.Fatalf("EncryptKey: %v", err)
+	}
+	if _, err := DecryptKey(encrypted, []byte("wrong passphrase")); err == nil {
+		t.Fatal("expected an error decrypting with the wrong passphrase")
+	}
+}
+
+func TestDecryptKeyNotEncrypted(t *testing.T) {
+	key := &Key{Priv: []byte("plaintext private key material")}
+	out, err := DecryptKey(key, []byte("irrelevant"))
+	if err != nil {
+		t.Fatalf("DecryptKey on a plaintext key should be a no-op, got: %v", err)
+	}
+	if !bytes.Equal(out.Priv, key.Priv) {
+		t.Fatal("expected the plaintext key back unchanged")
+	}
+}
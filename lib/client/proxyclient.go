@@ -0,0 +1,144 @@
+/*
+Copyright 2015 Gravitational, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package client
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+
+	"github.com/gravitational/trace"
+	"golang.org/x/crypto/ssh"
+)
+
+// certRenewalSubsystem is the SSH subsystem the proxy exposes for
+// certificate renewal/rekey requests, reached over the same multiplexed
+// SSH connection tsh already holds open to the proxy.
+const certRenewalSubsystem = "teleport-renew-cert"
+
+// ProxyClient is a connection to a Teleport proxy, used to request fresh
+// certificates for the renewal subsystem (see renewal.go).
+type ProxyClient struct {
+	// Client is the established SSH connection to the proxy.
+	Client *ssh.Client
+}
+
+// NewProxyClient wraps an already-authenticated SSH connection to a proxy.
+func NewProxyClient(client *ssh.Client) *ProxyClient {
+	return &ProxyClient{Client: client}
+}
+
+// certRenewalRequest is sent to the proxy's certRenewalSubsystem.
+type certRenewalRequest struct {
+	Username  string `json:"username"`
+	ProxyHost string `json:"proxy_host"`
+	PublicKey []byte `json:"public_key"`
+}
+
+// certRenewalResponse is the proxy's reply.
+type certRenewalResponse struct {
+	Cert  []byte `json:"cert,omitempty"`
+	Error string `json:"error,omitempty"`
+}
+
+// RenewSSH asks the proxy to re-sign the public key already certified by
+// key's certificate, extending its validity. The proxy only honors this
+// if the current certificate is still valid and hasn't been revoked; the
+// private key never leaves the caller.
+func (p *ProxyClient) RenewSSH(username, proxyHost string, key *Key) (*Key, error) {
+	pub, _, _, _, err := ssh.ParseAuthorizedKey(key.Cert)
+	if err != nil {
+		return nil, trace.Wrap(err)
+	}
+	cert, ok := pub.(*ssh.Certificate)
+	if !ok {
+		return nil, trace.BadParameter("key for %v does not hold a certificate to renew", proxyHost)
+	}
+	fresh, err := p.requestSignedCert(username, proxyHost, cert.Key)
+	if err != nil {
+		return nil, trace.Wrap(err)
+	}
+	fresh.Priv = key.Priv
+	return fresh, nil
+}
+
+// RekeySSH generates a brand new keypair locally, submits only its public
+// half to the proxy for signing, and returns a Key holding the new
+// private key alongside the freshly issued certificate.
+func (p *ProxyClient) RekeySSH(username, proxyHost string) (*Key, error) {
+	priv, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		return nil, trace.Wrap(err)
+	}
+	signer, err := ssh.NewSignerFromKey(priv)
+	if err != nil {
+		return nil, trace.Wrap(err)
+	}
+	fresh, err := p.requestSignedCert(username, proxyHost, signer.PublicKey())
+	if err != nil {
+		return nil, trace.Wrap(err)
+	}
+	fresh.Priv = pem.EncodeToMemory(&pem.Block{
+		Type:  "RSA PRIVATE KEY",
+		Bytes: x509.MarshalPKCS1PrivateKey(priv),
+	})
+	return fresh, nil
+}
+
+// requestSignedCert opens a session on the proxy's certificate renewal
+// subsystem, submits pub for signing, and returns the resulting Key (with
+// Priv left unset -- callers fill it in with the key pub belongs to).
+func (p *ProxyClient) requestSignedCert(username, proxyHost string, pub ssh.PublicKey) (*Key, error) {
+	session, err := p.Client.NewSession()
+	if err != nil {
+		return nil, trace.Wrap(err)
+	}
+	defer session.Close()
+
+	stdin, err := session.StdinPipe()
+	if err != nil {
+		return nil, trace.Wrap(err)
+	}
+	stdout, err := session.StdoutPipe()
+	if err != nil {
+		return nil, trace.Wrap(err)
+	}
+	if err := session.RequestSubsystem(certRenewalSubsystem); err != nil {
+		return nil, trace.Wrap(err)
+	}
+
+	req := certRenewalRequest{
+		Username:  username,
+		ProxyHost: proxyHost,
+		PublicKey: ssh.MarshalAuthorizedKey(pub),
+	}
+	if err := json.NewEncoder(stdin).Encode(&req); err != nil {
+		return nil, trace.Wrap(err)
+	}
+	stdin.Close()
+
+	var resp certRenewalResponse
+	if err := json.NewDecoder(stdout).Decode(&resp); err != nil {
+		return nil, trace.Wrap(err)
+	}
+	if resp.Error != "" {
+		return nil, trace.BadParameter("%s", resp.Error)
+	}
+	return &Key{Cert: resp.Cert, ProxyHost: proxyHost}, nil
+}
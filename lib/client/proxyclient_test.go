@@ -0,0 +1,236 @@
+/*
+Copyright 2015 Gravitational, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package client
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/json"
+	"net"
+	"testing"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// TestRenewSSH exercises the full round trip: a client cert past its
+// renewal window is renewed over the subsystem protocol, and the server's
+// reply certifies the same public key the original cert held.
+func TestRenewSSH(t *testing.T) {
+	caSigner, hostKey, listener := startRenewalServer(t)
+	defer listener.Close()
+
+	clientRSAKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("generating client key: %v", err)
+	}
+	clientSigner, err := ssh.NewSignerFromKey(clientRSAKey)
+	if err != nil {
+		t.Fatalf("wrapping client key: %v", err)
+	}
+	origCert := mustSignCert(t, caSigner, clientSigner.PublicKey(), time.Now().Add(-time.Minute))
+
+	conn := dialRenewalServer(t, listener, clientSigner)
+	defer conn.Close()
+
+	proxy := NewProxyClient(conn)
+	fresh, err := proxy.RenewSSH("alice", "proxy.example.com", &Key{
+		Priv: []byte("original-private-key"),
+		Cert: ssh.MarshalAuthorizedKey(origCert),
+	})
+	if err != nil {
+		t.Fatalf("RenewSSH: %v", err)
+	}
+	if string(fresh.Priv) != "original-private-key" {
+		t.Fatalf("RenewSSH should preserve the original private key, got %q", fresh.Priv)
+	}
+	if fresh.ProxyHost != "proxy.example.com" {
+		t.Fatalf("unexpected ProxyHost: %q", fresh.ProxyHost)
+	}
+
+	renewedPub, _, _, _, err := ssh.ParseAuthorizedKey(fresh.Cert)
+	if err != nil {
+		t.Fatalf("parsing renewed cert: %v", err)
+	}
+	renewedCert := renewedPub.(*ssh.Certificate)
+	if string(renewedCert.Key.Marshal()) != string(clientSigner.PublicKey().Marshal()) {
+		t.Fatal("renewed certificate does not certify the original public key")
+	}
+	if renewedCert.ValidBefore <= origCert.ValidBefore {
+		t.Fatal("expected the renewed certificate to have a later expiry")
+	}
+}
+
+// TestRekeySSH verifies that RekeySSH returns a Key whose private key
+// certifies the same certificate it generated the public half for.
+func TestRekeySSH(t *testing.T) {
+	_, _, listener := startRenewalServer(t)
+	defer listener.Close()
+
+	bootstrapRSAKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("generating bootstrap key: %v", err)
+	}
+	bootstrapSigner, err := ssh.NewSignerFromKey(bootstrapRSAKey)
+	if err != nil {
+		t.Fatalf("wrapping bootstrap key: %v", err)
+	}
+
+	conn := dialRenewalServer(t, listener, bootstrapSigner)
+	defer conn.Close()
+
+	proxy := NewProxyClient(conn)
+	fresh, err := proxy.RekeySSH("alice", "proxy.example.com")
+	if err != nil {
+		t.Fatalf("RekeySSH: %v", err)
+	}
+
+	freshSigner, err := ssh.ParsePrivateKey(fresh.Priv)
+	if err != nil {
+		t.Fatalf("parsing fresh private key: %v", err)
+	}
+	certPub, _, _, _, err := ssh.ParseAuthorizedKey(fresh.Cert)
+	if err != nil {
+		t.Fatalf("parsing fresh cert: %v", err)
+	}
+	cert := certPub.(*ssh.Certificate)
+	if string(cert.Key.Marshal()) != string(freshSigner.PublicKey().Marshal()) {
+		t.Fatal("rekeyed certificate does not certify the returned private key")
+	}
+}
+
+func mustSignCert(t *testing.T, ca ssh.Signer, pub ssh.PublicKey, validBefore time.Time) *ssh.Certificate {
+	cert := &ssh.Certificate{
+		Key:             pub,
+		CertType:        ssh.UserCert,
+		ValidPrincipals: []string{"alice"},
+		ValidAfter:      uint64(time.Now().Add(-time.Hour).Unix()),
+		ValidBefore:     uint64(validBefore.Unix()),
+	}
+	if err := cert.SignCert(rand.Reader, ca); err != nil {
+		t.Fatalf("signing cert: %v", err)
+	}
+	return cert
+}
+
+// startRenewalServer spins up an in-process SSH server implementing the
+// certRenewalSubsystem: it signs whatever public key it's handed with a
+// fresh one-hour certificate, regardless of the requested username.
+func startRenewalServer(t *testing.T) (ssh.Signer, ssh.Signer, net.Listener) {
+	caRSAKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("generating CA key: %v", err)
+	}
+	caSigner, err := ssh.NewSignerFromKey(caRSAKey)
+	if err != nil {
+		t.Fatalf("wrapping CA key: %v", err)
+	}
+
+	hostRSAKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("generating host key: %v", err)
+	}
+	hostSigner, err := ssh.NewSignerFromKey(hostRSAKey)
+	if err != nil {
+		t.Fatalf("wrapping host key: %v", err)
+	}
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listening: %v", err)
+	}
+	go runRenewalServer(t, listener, hostSigner, caSigner)
+	return caSigner, hostSigner, listener
+}
+
+func dialRenewalServer(t *testing.T, listener net.Listener, signer ssh.Signer) *ssh.Client {
+	clientConfig := &ssh.ClientConfig{
+		User:            "alice",
+		Auth:            []ssh.AuthMethod{ssh.PublicKeys(signer)},
+		HostKeyCallback: ssh.InsecureIgnoreHostKey(),
+	}
+	conn, err := ssh.Dial("tcp", listener.Addr().String(), clientConfig)
+	if err != nil {
+		t.Fatalf("dialing: %v", err)
+	}
+	return conn
+}
+
+func runRenewalServer(t *testing.T, listener net.Listener, hostKey, ca ssh.Signer) {
+	nc, err := listener.Accept()
+	if err != nil {
+		return
+	}
+	defer nc.Close()
+
+	config := &ssh.ServerConfig{NoClientAuth: true}
+	config.AddHostKey(hostKey)
+
+	conn, chans, reqs, err := ssh.NewServerConn(nc, config)
+	if err != nil {
+		return
+	}
+	defer conn.Close()
+	go ssh.DiscardRequests(reqs)
+
+	for newChannel := range chans {
+		if newChannel.ChannelType() != "session" {
+			newChannel.Reject(ssh.UnknownChannelType, "unsupported channel type")
+			continue
+		}
+		channel, requests, err := newChannel.Accept()
+		if err != nil {
+			return
+		}
+
+		for req := range requests {
+			if req.Type != "subsystem" {
+				if req.WantReply {
+					req.Reply(false, nil)
+				}
+				continue
+			}
+			req.Reply(true, nil)
+
+			var reqBody certRenewalRequest
+			if err := json.NewDecoder(channel).Decode(&reqBody); err != nil {
+				channel.Close()
+				return
+			}
+			pub, _, _, _, err := ssh.ParseAuthorizedKey(reqBody.PublicKey)
+			if err != nil {
+				channel.Close()
+				return
+			}
+			cert := &ssh.Certificate{
+				Key:             pub,
+				CertType:        ssh.UserCert,
+				ValidPrincipals: []string{reqBody.Username},
+				ValidAfter:      uint64(time.Now().Add(-time.Minute).Unix()),
+				ValidBefore:     uint64(time.Now().Add(time.Hour).Unix()),
+			}
+			if err := cert.SignCert(rand.Reader, ca); err != nil {
+				channel.Close()
+				return
+			}
+			resp := certRenewalResponse{Cert: ssh.MarshalAuthorizedKey(cert)}
+			json.NewEncoder(channel).Encode(&resp)
+			channel.Close()
+			return
+		}
+	}
+}
@@ -0,0 +1,172 @@
+/*
+Copyright 2015 Gravitational, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package client
+
+import (
+	"bytes"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/gravitational/trace"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/agent"
+	"golang.org/x/net/context"
+)
+
+// RenewalPolicy configures when and how LocalKeyAgent renews a certificate
+// before it expires.
+type RenewalPolicy struct {
+	// Window is how long before a cert's ValidBefore it should be renewed.
+	// Defaults to 25% of the cert's total lifetime when zero.
+	Window time.Duration
+
+	// CheckInterval is how often StartRenewalLoop wakes up to check
+	// expiration of the stored certs. Defaults to one minute when zero.
+	CheckInterval time.Duration
+
+	// Rekey, when true, generates a new keypair on renewal (RekeySSH)
+	// instead of re-signing the existing public key (RenewSSH).
+	Rekey bool
+}
+
+const (
+	defaultRenewalCheckInterval = time.Minute
+	defaultRenewalWindowPercent = 0.25
+)
+
+// StartRenewalLoop spawns a goroutine that periodically checks every cert
+// held by the agent's keystore and renews it once it enters policy's renewal
+// window, replacing the on-disk key and the in-process/external agent
+// identities so any live SSH sessions keep working uninterrupted.
+func (a *LocalKeyAgent) StartRenewalLoop(ctx context.Context, proxy *ProxyClient, username string, policy RenewalPolicy) {
+	interval := policy.CheckInterval
+	if interval == 0 {
+		interval = defaultRenewalCheckInterval
+	}
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				if err := a.renewIfNeeded(proxy, username, policy); err != nil {
+					log.Warnf("[KEY AGENT] cert renewal failed: %v", err)
+				}
+			}
+		}
+	}()
+}
+
+// renewIfNeeded renews every cert stored for username that has entered its
+// renewal window.
+func (a *LocalKeyAgent) renewIfNeeded(proxy *ProxyClient, username string, policy RenewalPolicy) error {
+	keys, err := a.keyStore.GetKeys(username)
+	if err != nil {
+		return trace.Wrap(err)
+	}
+	for _, key := range keys {
+		cert, _, _, _, err := ssh.ParseAuthorizedKey(key.Cert)
+		if err != nil {
+			continue
+		}
+		sshCert, ok := cert.(*ssh.Certificate)
+		if !ok {
+			continue
+		}
+		if !needsRenewal(sshCert, policy) {
+			continue
+		}
+		var fresh *Key
+		if policy.Rekey {
+			fresh, err = proxy.RekeySSH(username, key.ProxyHost)
+		} else {
+			fresh, err = proxy.RenewSSH(username, key.ProxyHost, key)
+		}
+		if err != nil {
+			return trace.Wrap(err)
+		}
+		if err := a.replaceKey(key.ProxyHost, username, fresh); err != nil {
+			return trace.Wrap(err)
+		}
+		log.Debugf("[KEY AGENT] renewed cert for %s@%s", username, key.ProxyHost)
+	}
+	return nil
+}
+
+// needsRenewal reports whether cert has entered its renewal window. A cert
+// that has already expired (now >= ValidBefore) always needs renewal --
+// computed separately from the window check below because ValidBefore-now
+// is unsigned and would underflow into a huge value once the cert expires.
+func needsRenewal(cert *ssh.Certificate, policy RenewalPolicy) bool {
+	now := uint64(time.Now().Unix())
+	if cert.ValidBefore == ssh.CertTimeInfinity || cert.ValidBefore <= cert.ValidAfter {
+		return false
+	}
+	if now >= cert.ValidBefore {
+		return true
+	}
+	lifetime := cert.ValidBefore - cert.ValidAfter
+	window := policy.Window
+	if window == 0 {
+		window = time.Duration(float64(lifetime)*defaultRenewalWindowPercent) * time.Second
+	}
+	return cert.ValidBefore-now < uint64(window.Seconds())
+}
+
+// replaceKey swaps the on-disk identity for proxyHost/username with fresh
+// (FSLocalKeyStore.AddKey writes via temp file + rename so the on-disk
+// state is never observed half-written), then removes the old agent
+// identity and adds the new one so existing connections signed by the
+// stale key remain valid until renewed while new connections pick up
+// fresh immediately.
+func (a *LocalKeyAgent) replaceKey(proxyHost, username string, fresh *Key) error {
+	stale, err := a.keyStore.GetKey(proxyHost, username)
+	if err != nil {
+		return trace.Wrap(err)
+	}
+	if err := a.keyStore.AddKey(proxyHost, username, fresh); err != nil {
+		return trace.Wrap(err)
+	}
+	a.removeAgentIdentity(stale)
+	return a.AddKey(proxyHost, username, fresh)
+}
+
+// removeAgentIdentity evicts stale's public key from both the in-process
+// keyring and the external ssh-agent (if any), leaving the on-disk
+// keystore untouched -- replaceKey has already overwritten it with the
+// fresh key by the time this runs.
+func (a *LocalKeyAgent) removeAgentIdentity(stale *Key) {
+	pubKey, _, _, _, err := ssh.ParseAuthorizedKey(stale.Cert)
+	if err != nil || pubKey == nil {
+		return
+	}
+	removeFrom := func(ag agent.Agent) {
+		keys, _ := ag.List()
+		for _, k := range keys {
+			if bytes.Contains(pubKey.Marshal(), k.Blob) {
+				ag.Remove(k)
+				break
+			}
+		}
+	}
+	removeFrom(a.Agent)
+	if a.sshAgent != nil {
+		removeFrom(a.sshAgent)
+	}
+}
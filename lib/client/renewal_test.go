@@ -0,0 +1,59 @@
+/*
+Copyright 2015 Gravitational, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package client
+
+import (
+	"testing"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+)
+
+func TestNeedsRenewalAlreadyExpired(t *testing.T) {
+	now := uint64(time.Now().Unix())
+	cert := &ssh.Certificate{
+		ValidAfter:  now - uint64(time.Hour.Seconds()),
+		ValidBefore: now - 1, // expired a second ago
+	}
+	if !needsRenewal(cert, RenewalPolicy{}) {
+		t.Fatal("expected an already-expired certificate to need renewal")
+	}
+}
+
+func TestNeedsRenewalWithinWindow(t *testing.T) {
+	now := uint64(time.Now().Unix())
+	cert := &ssh.Certificate{
+		ValidAfter:  now - uint64(time.Hour.Seconds()),
+		ValidBefore: now + 30, // about to expire
+	}
+	policy := RenewalPolicy{Window: time.Minute}
+	if !needsRenewal(cert, policy) {
+		t.Fatal("expected a soon-to-expire certificate to need renewal")
+	}
+}
+
+func TestNeedsRenewalNotYet(t *testing.T) {
+	now := uint64(time.Now().Unix())
+	cert := &ssh.Certificate{
+		ValidAfter:  now - uint64(time.Hour.Seconds()),
+		ValidBefore: now + uint64(time.Hour.Seconds()),
+	}
+	policy := RenewalPolicy{Window: time.Minute}
+	if needsRenewal(cert, policy) {
+		t.Fatal("did not expect a freshly-issued certificate to need renewal")
+	}
+}
@@ -0,0 +1,145 @@
+/*
+Copyright 2015 Gravitational, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package client
+
+import (
+	"io"
+	"strings"
+
+	"github.com/gravitational/trace"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/agent"
+)
+
+// Signer is anything capable of proving possession of a public key without
+// handing over the corresponding private key material. It lets
+// LocalKeyAgent authenticate using keys that never enter this process:
+// an external ssh-agent, a PKCS#11 token, a cloud KMS key, or a YubiKey.
+type Signer interface {
+	// Public returns the public half of the key this Signer holds.
+	Public() ssh.PublicKey
+	// Sign signs data, typically by delegating to external hardware or a
+	// remote service.
+	Sign(rand io.Reader, data []byte) (*ssh.Signature, error)
+}
+
+// agentSigner adapts a single key held by an external ssh-agent (identified
+// by its public key fingerprint) into a Signer.
+type agentSigner struct {
+	agent  agent.Agent
+	public ssh.PublicKey
+}
+
+func (s *agentSigner) Public() ssh.PublicKey { return s.public }
+
+func (s *agentSigner) Sign(rand io.Reader, data []byte) (*ssh.Signature, error) {
+	sig, err := s.agent.Sign(s.public, data)
+	if err != nil {
+		return nil, trace.Wrap(err)
+	}
+	return sig, nil
+}
+
+// NewSignerFromURI builds a Signer from a URI describing where its private
+// key material lives, e.g.:
+//
+//	agent://<pubkey-fingerprint>  - delegate to the ambient ssh-agent
+//	pkcs11:...                    - a PKCS#11 hardware token
+//	kms:aws://key-id              - a key held in AWS KMS
+//	kms:gcp://key-id              - a key held in GCP KMS
+//
+// Only the agent:// scheme is implemented directly here; the others are
+// recognized but return trace.NotImplemented until a backend is wired up,
+// so callers can fail fast with a clear error rather than silently falling
+// back to on-disk keys.
+func NewSignerFromURI(uri string, externalAgent agent.Agent) (Signer, error) {
+	switch {
+	case strings.HasPrefix(uri, "agent://"):
+		fingerprint := strings.TrimPrefix(uri, "agent://")
+		if externalAgent == nil {
+			return nil, trace.BadParameter("agent:// signer requires a running ssh-agent")
+		}
+		keys, err := externalAgent.List()
+		if err != nil {
+			return nil, trace.Wrap(err)
+		}
+		for _, k := range keys {
+			if ssh.FingerprintSHA256(k) == fingerprint {
+				return &agentSigner{agent: externalAgent, public: k}, nil
+			}
+		}
+		return nil, trace.NotFound("no key with fingerprint %v in ssh-agent", fingerprint)
+	case strings.HasPrefix(uri, "pkcs11:"):
+		return nil, trace.NotImplemented("pkcs11 signer backend is not wired up yet")
+	case strings.HasPrefix(uri, "kms:"):
+		return nil, trace.NotImplemented("KMS signer backend is not wired up yet")
+	default:
+		return nil, trace.BadParameter("unrecognized signer URI: %v", uri)
+	}
+}
+
+// SignerKeyStore is a LocalKeyStore that keeps only the certificate and
+// public key on disk, delegating all signing to an external Signer so the
+// private key material never has to be loadable by this process.
+type SignerKeyStore struct {
+	LocalKeyStore
+	signers map[string]Signer // keyed by "proxyHost|username"
+}
+
+// NewSignerKeyStore wraps an existing LocalKeyStore (used for certs, known
+// hosts, etc.) with no signers registered; use SetSigner to associate a
+// Signer with a proxyHost/username.
+func NewSignerKeyStore(inner LocalKeyStore) *SignerKeyStore {
+	return &SignerKeyStore{LocalKeyStore: inner, signers: make(map[string]Signer)}
+}
+
+// SetSigner registers the Signer to use for proxyHost/username in place of
+// any on-disk private key.
+func (s *SignerKeyStore) SetSigner(proxyHost, username string, signer Signer) {
+	s.signers[proxyHost+"|"+username] = signer
+}
+
+// AuthMethodFromSigners builds an ssh.AuthMethod that proves possession of
+// the Signer registered for proxyHost/username via ssh.PublicKeysCallback,
+// without ever exposing private key material to the ssh package's
+// in-process keyring. It offers only that one identity -- offering every
+// registered signer regardless of destination would let a compromised or
+// misconfigured proxy harvest public keys belonging to other proxies/users.
+func (s *SignerKeyStore) AuthMethodFromSigners(proxyHost, username string) ssh.AuthMethod {
+	return ssh.PublicKeysCallback(func() ([]ssh.Signer, error) {
+		signer, ok := s.signers[proxyHost+"|"+username]
+		if !ok {
+			return nil, trace.NotFound("no signer registered for %v@%v", username, proxyHost)
+		}
+		return []ssh.Signer{sshSignerFromSigner(signer)}, nil
+	})
+}
+
+// sshSignerFromSigner adapts our Signer interface to golang.org/x/crypto/ssh.Signer.
+func sshSignerFromSigner(signer Signer) ssh.Signer {
+	return &signerAdapter{signer}
+}
+
+type signerAdapter struct {
+	Signer
+}
+
+func (a *signerAdapter) PublicKey() ssh.PublicKey { return a.Public() }
+
+func (a *signerAdapter) Sign(rand io.Reader, data []byte) (*ssh.Signature, error) {
+	return a.Signer.Sign(rand, data)
+}
@@ -0,0 +1,115 @@
+/*
+Copyright 2015 Gravitational, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package client
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"errors"
+	"net"
+	"testing"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// dialWithSigners attempts an SSH handshake against an in-process server
+// using method, recording the public key(s) the client actually offered.
+func dialWithSigners(t *testing.T, method ssh.AuthMethod) []string {
+	hostRSAKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("generating host key: %v", err)
+	}
+	hostSigner, err := ssh.NewSignerFromKey(hostRSAKey)
+	if err != nil {
+		t.Fatalf("wrapping host key: %v", err)
+	}
+
+	var offered []string
+	config := &ssh.ServerConfig{
+		PublicKeyCallback: func(conn ssh.ConnMetadata, key ssh.PublicKey) (*ssh.Permissions, error) {
+			offered = append(offered, string(key.Marshal()))
+			return nil, errors.New("access denied")
+		},
+	}
+	config.AddHostKey(hostSigner)
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listening: %v", err)
+	}
+	defer listener.Close()
+
+	serverDone := make(chan struct{})
+	go func() {
+		defer close(serverDone)
+		nc, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		defer nc.Close()
+		ssh.NewServerConn(nc, config)
+	}()
+
+	clientConfig := &ssh.ClientConfig{
+		User:            "alice",
+		Auth:            []ssh.AuthMethod{method},
+		HostKeyCallback: ssh.InsecureIgnoreHostKey(),
+	}
+	// The handshake is expected to fail since PublicKeyCallback always
+	// denies -- we only care which keys were offered along the way.
+	ssh.Dial("tcp", listener.Addr().String(), clientConfig)
+	<-serverDone
+	return offered
+}
+
+func newTestSigner(t *testing.T) (Signer, ssh.PublicKey) {
+	priv, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("generating key: %v", err)
+	}
+	signer, err := ssh.NewSignerFromKey(priv)
+	if err != nil {
+		t.Fatalf("wrapping key: %v", err)
+	}
+	return &agentSigner{agent: nil, public: signer.PublicKey()}, signer.PublicKey()
+}
+
+// TestAuthMethodFromSignersScoping verifies that AuthMethodFromSigners only
+// offers the signer registered for the exact proxy/username it's asked
+// about, never every signer the SignerKeyStore knows of.
+func TestAuthMethodFromSignersScoping(t *testing.T) {
+	sks := NewSignerKeyStore(nil)
+	aliceSigner, alicePub := newTestSigner(t)
+	bobSigner, bobPub := newTestSigner(t)
+	sks.SetSigner("proxy-a.example.com", "alice", aliceSigner)
+	sks.SetSigner("proxy-b.example.com", "bob", bobSigner)
+
+	method := sks.AuthMethodFromSigners("proxy-a.example.com", "alice")
+	offered := dialWithSigners(t, method)
+
+	if len(offered) != 1 {
+		t.Fatalf("expected exactly one offered key, got %d", len(offered))
+	}
+	if offered[0] != string(alicePub.Marshal()) {
+		t.Fatal("offered key does not match the one registered for proxy-a/alice")
+	}
+	for _, o := range offered {
+		if o == string(bobPub.Marshal()) {
+			t.Fatal("bob's key for a different proxy/username must never be offered")
+		}
+	}
+}
@@ -0,0 +1,72 @@
+/*
+Copyright 2015 Gravitational, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package client
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+
+	"github.com/gravitational/trace"
+	"golang.org/x/crypto/ssh"
+)
+
+// sshConfigBeginMarker and sshConfigEndMarker fence the block tsh config
+// merges into ~/.ssh/config, so re-running it replaces only what it wrote.
+const (
+	sshConfigBeginMarker = "# BEGIN teleport"
+	sshConfigEndMarker   = "# END teleport"
+)
+
+// WriteKnownHostsCAEntries writes an OpenSSH known_hosts @cert-authority
+// line for every CA this agent has cached (via AddHostSignersToCache), so
+// plain `ssh`/`scp` trust the same host certificates Teleport itself does.
+func (a *LocalKeyAgent) WriteKnownHostsCAEntries(w io.Writer) error {
+	for _, ca := range a.cas {
+		checkers, err := ca.V2().Checkers()
+		if err != nil {
+			return trace.Wrap(err)
+		}
+		for _, checker := range checkers {
+			// MarshalAuthorizedKey already terminates the line with "\n".
+			marshaled := bytes.TrimRight(ssh.MarshalAuthorizedKey(checker), "\n")
+			if _, err := fmt.Fprintf(w, "@cert-authority *.%s %s\n", ca.DomainName, marshaled); err != nil {
+				return trace.Wrap(err)
+			}
+		}
+	}
+	return nil
+}
+
+// WriteSSHConfig writes an ssh_config Host block that routes connections to
+// *.<proxy host> through `tsh proxy ssh`, using the Teleport-issued identity
+// and certificate for the current user. The Host pattern is built from the
+// stored key's ProxyHost; the cluster names of cached CAs are not used.
+func (a *LocalKeyAgent) WriteSSHConfig(w io.Writer, username, proxyHost string) error {
+	key, err := a.keyStore.GetKey(proxyHost, username)
+	if err != nil {
+		return trace.Wrap(err)
+	}
+	_, err = fmt.Fprintf(w, `%s
+Host *.%s
+    ProxyCommand tsh proxy ssh %%h:%%p
+    IdentityFile ~/.tsh/keys/%s/%s
+    CertificateFile ~/.tsh/keys/%s/%s-cert.pub
+%s
+`, sshConfigBeginMarker, key.ProxyHost, proxyHost, username, proxyHost, username, sshConfigEndMarker)
+	return trace.Wrap(err)
+}
@@ -0,0 +1,144 @@
+/*
+Copyright 2015 Gravitational, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package client
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/rsa"
+	"strings"
+	"testing"
+
+	"github.com/gravitational/teleport/lib/services"
+	"github.com/gravitational/trace"
+	"golang.org/x/crypto/ssh"
+)
+
+// fakeKeyStore is a minimal in-memory LocalKeyStore, just enough to drive
+// WriteSSHConfig without touching disk.
+type fakeKeyStore struct {
+	keys map[string]*Key
+}
+
+func (f *fakeKeyStore) GetKeys(username string) ([]*Key, error) {
+	var out []*Key
+	for _, k := range f.keys {
+		out = append(out, k)
+	}
+	return out, nil
+}
+
+func (f *fakeKeyStore) AddKey(host, username string, key *Key) error {
+	if f.keys == nil {
+		f.keys = make(map[string]*Key)
+	}
+	f.keys[host+"|"+username] = key
+	return nil
+}
+
+func (f *fakeKeyStore) GetKey(proxyHost, username string) (*Key, error) {
+	key, ok := f.keys[proxyHost+"|"+username]
+	if !ok {
+		return nil, trace.NotFound("no key for %v@%v", username, proxyHost)
+	}
+	return key, nil
+}
+
+func (f *fakeKeyStore) DeleteKey(proxyHost, username string) error {
+	delete(f.keys, proxyHost+"|"+username)
+	return nil
+}
+
+func (f *fakeKeyStore) AddKnownHostKeys(hostId string, keys []ssh.PublicKey) error {
+	return nil
+}
+
+func (f *fakeKeyStore) GetKnownHostKeys(hostId string) ([]ssh.PublicKey, error) {
+	return nil, nil
+}
+
+// TestWriteKnownHostsCAEntries checks the known_hosts line produced for a
+// cached CA: exactly one "@cert-authority *.<domain> <key>" line per
+// checking key, with no doubled trailing newline (regression test for the
+// double-newline bug fixed in cc38bf7).
+func TestWriteKnownHostsCAEntries(t *testing.T) {
+	caRSAKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("generating CA key: %v", err)
+	}
+	caSigner, err := ssh.NewSignerFromKey(caRSAKey)
+	if err != nil {
+		t.Fatalf("wrapping CA key: %v", err)
+	}
+
+	a := &LocalKeyAgent{
+		cas: []services.CertAuthorityV1{
+			{
+				DomainName: "example.com",
+				Spec: services.CertAuthoritySpecV1{
+					CheckingKeys: [][]byte{ssh.MarshalAuthorizedKey(caSigner.PublicKey())},
+				},
+			},
+		},
+	}
+
+	var buf bytes.Buffer
+	if err := a.WriteKnownHostsCAEntries(&buf); err != nil {
+		t.Fatalf("WriteKnownHostsCAEntries: %v", err)
+	}
+
+	lines := strings.Split(strings.TrimRight(buf.String(), "\n"), "\n")
+	if len(lines) != 1 {
+		t.Fatalf("expected exactly one known_hosts line, got %d: %q", len(lines), buf.String())
+	}
+	if !strings.HasPrefix(lines[0], "@cert-authority *.example.com ") {
+		t.Fatalf("unexpected known_hosts line: %q", lines[0])
+	}
+	if strings.Contains(buf.String(), "\n\n") {
+		t.Fatal("known_hosts output has a doubled newline")
+	}
+}
+
+// TestWriteSSHConfig checks that the generated ssh_config block is fenced
+// by the teleport markers and points at the right identity/cert paths for
+// the requested proxy/username.
+func TestWriteSSHConfig(t *testing.T) {
+	store := &fakeKeyStore{}
+	if err := store.AddKey("proxy.example.com", "alice", &Key{ProxyHost: "proxy.example.com"}); err != nil {
+		t.Fatalf("AddKey: %v", err)
+	}
+	a := &LocalKeyAgent{keyStore: store}
+
+	var buf bytes.Buffer
+	if err := a.WriteSSHConfig(&buf, "alice", "proxy.example.com"); err != nil {
+		t.Fatalf("WriteSSHConfig: %v", err)
+	}
+
+	out := buf.String()
+	if !strings.HasPrefix(out, sshConfigBeginMarker) {
+		t.Fatal("expected output to start with the begin marker")
+	}
+	if !strings.HasSuffix(strings.TrimRight(out, "\n"), sshConfigEndMarker) {
+		t.Fatal("expected output to end with the end marker")
+	}
+	if !strings.Contains(out, "Host *.proxy.example.com") {
+		t.Fatalf("expected a Host block for the proxy host, got: %q", out)
+	}
+	if !strings.Contains(out, "keys/proxy.example.com/alice") {
+		t.Fatalf("expected the identity path to reference alice@proxy.example.com, got: %q", out)
+	}
+}
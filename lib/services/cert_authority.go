@@ -0,0 +1,183 @@
+/*
+Copyright 2015 Gravitational, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package services
+
+import (
+	"encoding/json"
+	"time"
+
+	"github.com/gravitational/teleport/lib/defaults"
+
+	"github.com/gravitational/trace"
+	"golang.org/x/crypto/ssh"
+)
+
+// V1 is the resource version used throughout the v1 migration layer.
+const V1 = "v1"
+
+// KindCertAuthority is the resource kind for a CertAuthorityV1.
+const KindCertAuthority = "cert_authority"
+
+// CertAuthType identifies which purpose a CA's keypair is used for.
+type CertAuthType string
+
+const (
+	// UserCA signs certificates presented by interactive users.
+	UserCA CertAuthType = "user"
+	// HostCA signs host certificates presented by teleport nodes.
+	HostCA CertAuthType = "host"
+)
+
+// Metadata is the common, versioned envelope every v1 resource carries.
+type Metadata struct {
+	Name      string            `json:"name"`
+	Namespace string            `json:"namespace,omitempty"`
+	Labels    map[string]string `json:"labels,omitempty"`
+}
+
+// CertAuthorityV0 is the pre-migration representation of a certificate
+// authority, as it used to be stored on disk/in the backend.
+type CertAuthorityV0 struct {
+	Type          CertAuthType
+	DomainName    string
+	CheckingKeys  [][]byte
+	SigningKeys   [][]byte
+	AllowedLogins []string
+}
+
+// V1 upgrades a CertAuthorityV0 into the current resource representation,
+// keeping the original object in rawObject. MarshalCertAuthority always
+// writes the V1 form and does not consult rawObject.
+func (ca *CertAuthorityV0) V1() *CertAuthorityV1 {
+	// Note: DomainName is intentionally left unset here -- it's populated
+	// directly by the proxy/auth server on CertAuthorityV1 values it hands
+	// to clients (see lib/client.LocalKeyAgent.AddHostSignersToCache), not
+	// by this legacy V0 compatibility path.
+	return &CertAuthorityV1{
+		Kind:    KindCertAuthority,
+		Version: V1,
+		Metadata: Metadata{
+			Name:      ca.DomainName,
+			Namespace: defaults.Namespace,
+		},
+		Spec: CertAuthoritySpecV1{
+			ClusterName:  ca.DomainName,
+			Type:         ca.Type,
+			CheckingKeys: ca.CheckingKeys,
+			SigningKeys:  ca.SigningKeys,
+		},
+		rawObject: *ca,
+	}
+}
+
+// CertAuthoritySpecV1 is the body of a CertAuthorityV1.
+type CertAuthoritySpecV1 struct {
+	// Type is whether this CA signs user or host certificates.
+	Type CertAuthType `json:"type"`
+	// ClusterName is the name of the cluster this CA belongs to.
+	ClusterName string `json:"cluster_name"`
+	// CheckingKeys are the CA's public keys, used to verify certificates
+	// it has signed.
+	CheckingKeys [][]byte `json:"checking_keys"`
+	// SigningKeys are the CA's private keys, used to sign new certificates.
+	SigningKeys [][]byte `json:"signing_keys,omitempty"`
+	// HostCertTTL is how long host certificates issued by SignHostCert are
+	// valid for before a node must rotate them. Zero means
+	// DefaultHostCertTTL.
+	HostCertTTL time.Duration `json:"host_cert_ttl,omitempty"`
+}
+
+// CertAuthorityV1 is a certificate authority trusted by the cluster.
+type CertAuthorityV1 struct {
+	Kind    string `json:"kind"`
+	Version string `json:"version"`
+	// DomainName is the name of the cluster this CA belongs to, kept at
+	// the top level (rather than only under Metadata/Spec) since
+	// lib/client's LocalKeyAgent has always addressed CAs by it directly.
+	DomainName string              `json:"domain_name"`
+	Metadata   Metadata            `json:"metadata"`
+	Spec       CertAuthoritySpecV1 `json:"spec"`
+
+	// rawObject holds the pre-migration object this CertAuthorityV1 was
+	// converted from, if any. It is not marshaled.
+	rawObject interface{}
+}
+
+// V2 returns ca itself; CertAuthorityV1 already exposes the Checkers()
+// accessor callers reach for via the V2() convention used by other
+// resource types in this package.
+func (ca *CertAuthorityV1) V2() *CertAuthorityV1 {
+	return ca
+}
+
+// Checkers parses and returns every one of the CA's public checking keys.
+func (ca *CertAuthorityV1) Checkers() ([]ssh.PublicKey, error) {
+	out := make([]ssh.PublicKey, 0, len(ca.Spec.CheckingKeys))
+	for _, keyBytes := range ca.Spec.CheckingKeys {
+		pub, _, _, _, err := ssh.ParseAuthorizedKey(keyBytes)
+		if err != nil {
+			return nil, trace.Wrap(err)
+		}
+		out = append(out, pub)
+	}
+	return out, nil
+}
+
+// CertAuthorityMarshaler converts certificate authorities to and from
+// their wire representation, transparently upgrading the legacy V0 format.
+type CertAuthorityMarshaler interface {
+	MarshalCertAuthority(ca *CertAuthorityV1) ([]byte, error)
+	UnmarshalCertAuthority(data []byte) (*CertAuthorityV1, error)
+}
+
+type teleportCertAuthorityMarshaler struct{}
+
+// GetCertAuthorityMarshaler returns the marshaler used for certificate
+// authorities.
+func GetCertAuthorityMarshaler() CertAuthorityMarshaler {
+	return &teleportCertAuthorityMarshaler{}
+}
+
+func (*teleportCertAuthorityMarshaler) MarshalCertAuthority(ca *CertAuthorityV1) ([]byte, error) {
+	data, err := json.Marshal(ca)
+	if err != nil {
+		return nil, trace.Wrap(err)
+	}
+	return data, nil
+}
+
+func (*teleportCertAuthorityMarshaler) UnmarshalCertAuthority(data []byte) (*CertAuthorityV1, error) {
+	var versioned struct {
+		Version string `json:"version"`
+	}
+	if err := json.Unmarshal(data, &versioned); err != nil {
+		return nil, trace.Wrap(err)
+	}
+	// no "version" field means this is a pre-migration V0 object.
+	if versioned.Version == "" {
+		var v0 CertAuthorityV0
+		if err := json.Unmarshal(data, &v0); err != nil {
+			return nil, trace.Wrap(err)
+		}
+		return v0.V1(), nil
+	}
+	var v1 CertAuthorityV1
+	if err := json.Unmarshal(data, &v1); err != nil {
+		return nil, trace.Wrap(err)
+	}
+	return &v1, nil
+}
@@ -0,0 +1,73 @@
+/*
+Copyright 2015 Gravitational, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package services
+
+import (
+	"crypto/rand"
+	"time"
+
+	"github.com/gravitational/trace"
+	"golang.org/x/crypto/ssh"
+)
+
+// DefaultHostCertTTL is used for SignHostCert when the CA's HostCertTTL
+// is unset, matching the lifetime teleport nodes have always assumed for
+// their (previously permanent) host keys minus a safety margin for rotation.
+const DefaultHostCertTTL = 24 * time.Hour * 30
+
+// SignHostCert issues a short-lived SSH host certificate over hostPubKey
+// (the node's own public key) for hostID, valid for the given principals
+// (typically the node's hostname and advertised addresses) and role, signed
+// by ca's host signing key. Nodes present the result as their HostKey
+// instead of a long-lived static key, and rotate before it expires.
+func (ca *CertAuthorityV1) SignHostCert(hostPubKey ssh.PublicKey, hostID string, principals []string, role string, ttl time.Duration) (*ssh.Certificate, error) {
+	if len(ca.Spec.SigningKeys) == 0 {
+		return nil, trace.BadParameter("CA %q has no host signing key", ca.Metadata.Name)
+	}
+	signer, err := ssh.ParsePrivateKey(ca.Spec.SigningKeys[0])
+	if err != nil {
+		return nil, trace.Wrap(err)
+	}
+	if ttl == 0 {
+		ttl = ca.hostCertTTL()
+	}
+	now := time.Now().UTC()
+	cert := &ssh.Certificate{
+		Key:             hostPubKey,
+		CertType:        ssh.HostCert,
+		KeyId:           hostID,
+		ValidPrincipals: principals,
+		ValidAfter:      uint64(now.Add(-time.Minute).Unix()),
+		ValidBefore:     uint64(now.Add(ttl).Unix()),
+		Permissions: ssh.Permissions{
+			Extensions: map[string]string{"role": role},
+		},
+	}
+	if err := cert.SignCert(rand.Reader, signer); err != nil {
+		return nil, trace.Wrap(err)
+	}
+	return cert, nil
+}
+
+// hostCertTTL returns the CA's configured HostCertTTL, falling back to
+// DefaultHostCertTTL when unset.
+func (ca *CertAuthorityV1) hostCertTTL() time.Duration {
+	if ca.Spec.HostCertTTL == 0 {
+		return DefaultHostCertTTL
+	}
+	return ca.Spec.HostCertTTL
+}
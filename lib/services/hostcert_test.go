@@ -0,0 +1,82 @@
+/*
+Copyright 2015 Gravitational, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package services
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"time"
+
+	"github.com/gravitational/teleport/lib/sshutils"
+
+	"golang.org/x/crypto/ssh"
+
+	. "gopkg.in/check.v1"
+)
+
+type HostCertSuite struct {
+}
+
+var _ = Suite(&HostCertSuite{})
+
+func (s *HostCertSuite) TestSignHostCert(c *C) {
+	caKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	c.Assert(err, IsNil)
+	caSigner, err := ssh.NewSignerFromKey(caKey)
+	c.Assert(err, IsNil)
+
+	caKeyPEM := pem.EncodeToMemory(&pem.Block{
+		Type:  "RSA PRIVATE KEY",
+		Bytes: x509.MarshalPKCS1PrivateKey(caKey),
+	})
+	ca := &CertAuthorityV1{
+		Metadata: Metadata{Name: "example.com"},
+		Spec: CertAuthoritySpecV1{
+			SigningKeys:  [][]byte{caKeyPEM},
+			CheckingKeys: [][]byte{ssh.MarshalAuthorizedKey(caSigner.PublicKey())},
+		},
+	}
+
+	hostKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	c.Assert(err, IsNil)
+	hostSigner, err := ssh.NewSignerFromKey(hostKey)
+	c.Assert(err, IsNil)
+
+	cert, err := ca.SignHostCert(hostSigner.PublicKey(), "node1", []string{"node1", "node1.example.com"}, "node", time.Hour)
+	c.Assert(err, IsNil)
+
+	// the certified key must be the host's own key, not the CA's.
+	c.Assert(cert.Key, DeepEquals, hostSigner.PublicKey())
+	c.Assert(cert.CertType, Equals, uint32(ssh.HostCert))
+	c.Assert(cert.ValidPrincipals, DeepEquals, []string{"node1", "node1.example.com"})
+
+	// the node must be able to build a working host key from its own
+	// private key plus the issued certificate, and a client trusting the
+	// CA must be able to verify it.
+	certSigner, err := ssh.NewCertSigner(cert, hostSigner)
+	c.Assert(err, IsNil)
+
+	checker := &ssh.CertChecker{
+		IsHostAuthority: func(auth ssh.PublicKey, address string) bool {
+			return sshutils.KeysEqual(auth, caSigner.PublicKey())
+		},
+	}
+	err = checker.CheckHostKey("node1:22", nil, certSigner.PublicKey())
+	c.Assert(err, IsNil)
+}